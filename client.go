@@ -0,0 +1,252 @@
+package main
+
+import (
+	"encoding/json"
+	"log"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/gorilla/websocket"
+)
+
+const (
+	writeChannelBuffer = 16
+	pingInterval       = 30 * time.Second
+	pongWait           = 60 * time.Second
+	writeWait          = 10 * time.Second
+	slowConsumerDrops  = 5 // consecutive dropped writes before we give up on a client
+)
+
+// Client is the actor wrapping one websocket connection: a reader goroutine
+// and a writer goroutine that only talk to each other through writeCh, so a
+// slow client can never block the broadcaster or other clients' writes.
+type Client struct {
+	ws *websocket.Conn
+
+	room     *Room
+	playerMu sync.Mutex // guards player, which handleHello can reassign mid-connection
+	player   Player
+	token    string
+
+	writeCh   chan interface{}
+	done      chan struct{}
+	closeOnce sync.Once
+
+	droppedWrites uint32 // atomic; consecutive dropped writes
+	lastAckTick   uint64 // highest simulation tick this client has been sent a snapshot for
+
+	txBytes uint64 // atomic; cumulative bytes written to this connection
+	rxBytes uint64 // atomic; cumulative bytes read from this connection
+}
+
+func newClient(ws *websocket.Conn, room *Room, player Player, token string, lastAckTick uint64) *Client {
+	return &Client{
+		ws:          ws,
+		room:        room,
+		player:      player,
+		token:       token,
+		writeCh:     make(chan interface{}, writeChannelBuffer),
+		done:        make(chan struct{}),
+		lastAckTick: lastAckTick,
+	}
+}
+
+// playerID returns the player this connection currently represents. It's
+// safe to call from any goroutine: handleHello can reassign the player
+// mid-connection once a reconnect token is validated, and send() is
+// called concurrently from the room's simulation goroutine and other
+// clients' reader goroutines relaying broadcasts.
+func (c *Client) playerID() string {
+	c.playerMu.Lock()
+	defer c.playerMu.Unlock()
+	return c.player.ID
+}
+
+// setPlayer reassigns which player this connection represents, along
+// with the freshly issued token that now proves that identity.
+func (c *Client) setPlayer(p Player, token string) {
+	c.playerMu.Lock()
+	defer c.playerMu.Unlock()
+	c.player = p
+	c.token = token
+}
+
+// send queues msg for delivery without blocking the caller. If the
+// client's writer can't keep up, the message is dropped and, past a
+// threshold of consecutive drops, the connection is closed as a slow
+// consumer rather than let it back up the broadcaster. send is called
+// concurrently on the same Client from the room's simulation goroutine
+// and other clients' reader goroutines relaying broadcasts, so
+// droppedWrites is an atomic counter rather than a plain field.
+func (c *Client) send(msg interface{}) {
+	select {
+	case <-c.done:
+		return
+	default:
+	}
+
+	select {
+	case c.writeCh <- msg:
+		atomic.StoreUint32(&c.droppedWrites, 0)
+	default:
+		dropped := atomic.AddUint32(&c.droppedWrites, 1)
+		metrics.recordDrop()
+		log.Printf("Player %s write channel full, dropping message (%d consecutive)", c.playerID(), dropped)
+		if dropped >= slowConsumerDrops {
+			log.Printf("Player %s is a slow consumer, closing connection", c.playerID())
+			metrics.recordSlowConsumerDisconnect()
+			c.close()
+		}
+	}
+}
+
+// recordRx counts bytes read from this connection against the
+// per-connection, per-room, and server-wide bandwidth windows.
+func (c *Client) recordRx(n int) {
+	atomic.AddUint64(&c.rxBytes, uint64(n))
+	c.room.rx.add(n)
+	metrics.recordRx(n)
+}
+
+// recordTx counts bytes written to this connection the same way.
+func (c *Client) recordTx(n int) {
+	atomic.AddUint64(&c.txBytes, uint64(n))
+	c.room.tx.add(n)
+	metrics.recordTx(n)
+}
+
+// close is called concurrently from the room's simulation goroutine,
+// other clients' reader goroutines (via send's slow-consumer path), and
+// this client's own readPump cleanup, so it needs to be safe against
+// more than one caller tripping it at once. sync.Once, not a
+// check-then-act select, is what makes that safe: two goroutines racing
+// a plain "select on done, else close" can both see it as open and both
+// call close(c.done), which panics.
+func (c *Client) close() {
+	c.closeOnce.Do(func() { close(c.done) })
+}
+
+// readPump owns the connection's read side and is the only goroutine that
+// calls ReadMessage. It runs on the handler's goroutine; when it returns,
+// the connection is torn down.
+func (c *Client) readPump() {
+	defer func() {
+		c.close()
+		c.ws.Close()
+		c.room.store.Disconnect(c.ws)
+		c.room.removeClient(c)
+		c.room.clearIntent(c.playerID())
+	}()
+
+	c.ws.SetReadDeadline(time.Now().Add(pongWait))
+	c.ws.SetPongHandler(func(string) error {
+		c.ws.SetReadDeadline(time.Now().Add(pongWait))
+		return nil
+	})
+
+	for {
+		_, msgBytes, err := c.ws.ReadMessage()
+		if err != nil {
+			if websocket.IsUnexpectedCloseError(err, websocket.CloseGoingAway, websocket.CloseAbnormalClosure) {
+				log.Printf("Error reading message: %v", err)
+			}
+			return
+		}
+		c.recordRx(len(msgBytes))
+
+		if !c.handleIncoming(msgBytes) {
+			return
+		}
+	}
+}
+
+// handleIncoming dispatches one envelope through the message registry.
+// It reports whether the connection should stay open: a handler error
+// means it already sent an error frame and close, so the caller should
+// stop reading.
+func (c *Client) handleIncoming(msgBytes []byte) bool {
+	var env Envelope
+	if err := json.Unmarshal(msgBytes, &env); err != nil {
+		c.fail(&ProtocolError{Message: "malformed envelope: " + err.Error()})
+		return false
+	}
+
+	handler, ok := messageHandlers[env.Type]
+	if !ok {
+		c.fail(&ProtocolError{Message: "unknown message type: " + env.Type})
+		return false
+	}
+
+	if err := handler(c, env.Payload); err != nil {
+		c.fail(err)
+		return false
+	}
+
+	return true
+}
+
+// closeRequest asks writePump to send a close frame and stop. It travels
+// through writeCh like any other message so it's ordered after whatever
+// was already queued (the error frame, in particular).
+type closeRequest struct {
+	payload []byte
+}
+
+// fail queues a typed error frame followed by a close frame whose code
+// matches the error kind. Both go through writeCh so writePump remains
+// the only goroutine that ever writes to the connection.
+func (c *Client) fail(err error) {
+	log.Printf("Player %s: %v", c.playerID(), err)
+
+	c.send(Envelope{Type: "error", Payload: mustMarshal(errorPayload{Message: err.Error()})})
+	c.send(closeRequest{payload: errorToWSCloseMessage(err)})
+}
+
+// sendError reports a rejected request to the client without ending the
+// session, for recoverable validation failures (an empty or over-length
+// chat message, say) as opposed to fail(), which is for violations
+// serious enough that the connection shouldn't continue at all.
+func (c *Client) sendError(message string) {
+	c.send(Envelope{Type: "error", Payload: mustMarshal(errorPayload{Message: message})})
+}
+
+// writePump owns the connection's write side and is the only goroutine
+// that writes to it, serializing broadcast deliveries and keepalive pings
+// behind writeCh so neither blocks the other.
+func (c *Client) writePump() {
+	ticker := time.NewTicker(pingInterval)
+	defer func() {
+		ticker.Stop()
+		c.ws.Close()
+	}()
+
+	for {
+		select {
+		case msg := <-c.writeCh:
+			c.ws.SetWriteDeadline(time.Now().Add(writeWait))
+			if cr, ok := msg.(closeRequest); ok {
+				c.ws.WriteControl(websocket.CloseMessage, cr.payload, time.Now().Add(writeWait))
+				return
+			}
+			data, err := json.Marshal(msg)
+			if err != nil {
+				log.Printf("Error marshaling message for player %s: %v", c.playerID(), err)
+				continue
+			}
+			if err := c.ws.WriteMessage(websocket.TextMessage, data); err != nil {
+				log.Printf("Error writing to player %s: %v", c.playerID(), err)
+				return
+			}
+			c.recordTx(len(data))
+		case <-ticker.C:
+			c.ws.SetWriteDeadline(time.Now().Add(writeWait))
+			if err := c.ws.WriteMessage(websocket.PingMessage, nil); err != nil {
+				log.Printf("Error pinging player %s: %v", c.playerID(), err)
+				return
+			}
+		case <-c.done:
+			return
+		}
+	}
+}
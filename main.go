@@ -3,12 +3,11 @@ package main
 import (
 	"crypto/rand"
 	"encoding/hex"
-	"encoding/json"
 	"fmt"
 	"github.com/gorilla/websocket"
 	"log"
 	"net/http"
-	"sync"
+	"sync/atomic"
 	"time"
 )
 
@@ -18,32 +17,17 @@ var upgrader = websocket.Upgrader{
 	CheckOrigin:     func(r *http.Request) bool { return true }, // Allow all origins for testing
 }
 
+// Player is world state shared with every client in the room, so it
+// deliberately carries no secret: a player's reconnect token lives only
+// on their own Client, never here.
 type Player struct {
-	ID       string    `json:"id"`
-	X        float64   `json:"x"`
-	Y        float64   `json:"y"`
-	LastSeen time.Time `json:"-"` // Track when we last heard from player
+	ID              string    `json:"id"`
+	X               float64   `json:"x"`
+	Y               float64   `json:"y"`
+	LastSeen        time.Time `json:"-"` // Track when we last heard from player
+	LastChangedTick uint64    `json:"-"` // Simulation tick this position was set on, for snapshot diffing
 }
 
-type MoveMessage struct {
-	Type     string  `json:"type"`
-	PlayerID string  `json:"playerId"`
-	X        float64 `json:"x"`
-	Y        float64 `json:"y"`
-}
-
-type PlayerStore struct {
-	players map[*websocket.Conn]Player
-	conns   map[string]*websocket.Conn // Map player IDs to connections
-	mu      sync.Mutex
-}
-
-var store = PlayerStore{
-	players: make(map[*websocket.Conn]Player),
-	conns:   make(map[string]*websocket.Conn),
-}
-var broadcast = make(chan MoveMessage)
-
 // Generate a secure random ID
 func generateSecureID() string {
 	bytes := make([]byte, 4) // 8 hex characters
@@ -56,265 +40,109 @@ func generateSecureID() string {
 	return hex.EncodeToString(bytes)
 }
 
-func (ps *PlayerStore) Add(ws *websocket.Conn, player Player) {
-	ps.mu.Lock()
-	defer ps.mu.Unlock()
-
-	// First check if this player ID already exists and clean it up
-	if existingConn, found := ps.conns[player.ID]; found {
-		log.Printf("Player %s already exists, removing old connection", player.ID)
-		delete(ps.players, existingConn)
-		existingConn.Close()
-	}
-
-	ps.players[ws] = player
-	ps.conns[player.ID] = ws
-
-	log.Printf("Added player %s. Total players: %d", player.ID, len(ps.players))
-}
-
-func (ps *PlayerStore) Update(ws *websocket.Conn, x, y float64) {
-	ps.mu.Lock()
-	defer ps.mu.Unlock()
-
-	if player, ok := ps.players[ws]; ok {
-		player.X = x
-		player.Y = y
-		player.LastSeen = time.Now()
-		ps.players[ws] = player
-
-		if x != 0 || y != 0 {
-			log.Printf("Updated player %s position to (%.2f, %.2f)", player.ID, x, y)
-		}
-	} else {
-		log.Printf("Warning: Tried to update non-existent player")
-	}
-}
-
-func (ps *PlayerStore) Delete(ws *websocket.Conn) {
-	ps.mu.Lock()
-	defer ps.mu.Unlock()
-
-	if player, ok := ps.players[ws]; ok {
-		log.Printf("Removing player %s", player.ID)
-		delete(ps.conns, player.ID)
-		delete(ps.players, ws)
-		log.Printf("Player %s disconnected, total players: %d", player.ID, len(ps.players))
-	} else {
-		log.Printf("Warning: Tried to delete unknown player connection")
-	}
-}
-
-func (ps *PlayerStore) GetAllPlayers() []Player {
-	ps.mu.Lock()
-	defer ps.mu.Unlock()
-
-	players := make([]Player, 0, len(ps.players))
-	for _, player := range ps.players {
-		players = append(players, player)
-	}
-	return players
-}
-
-func (ps *PlayerStore) Range(f func(ws *websocket.Conn, player Player) bool) {
-	ps.mu.Lock()
-	defer ps.mu.Unlock()
-
-	for ws, player := range ps.players {
-		if !f(ws, player) {
-			break
-		}
-	}
-}
-
-func (ps *PlayerStore) CleanupInactivePlayers() {
-	ps.mu.Lock()
-	defer ps.mu.Unlock()
-
-	now := time.Now()
-	timeout := 30 * time.Second
-
-	for ws, player := range ps.players {
-		if now.Sub(player.LastSeen) > timeout {
-			log.Printf("Removing inactive player %s", player.ID)
-			delete(ps.conns, player.ID)
-			delete(ps.players, ws)
-			ws.Close()
-		}
-	}
-}
-
 func handleConnections(w http.ResponseWriter, r *http.Request) {
-	ws, err := upgrader.Upgrade(w, r, nil)
-	if err != nil {
-		log.Printf("WebSocket upgrade error: %v", err)
+	passphrase := r.URL.Query().Get("room")
+	room, ok := rooms.Get(passphrase)
+	if !ok {
+		http.Error(w, "room not found", http.StatusNotFound)
 		return
 	}
 
-	// Set reasonable timeouts
-	ws.SetReadDeadline(time.Now().Add(120 * time.Second))
-	ws.SetWriteDeadline(time.Now().Add(10 * time.Second))
-
-	// Register new player with a secure ID
-	playerID := generateSecureID()
-	player := Player{
-		ID:       playerID,
-		X:        0,
-		Y:        0,
-		LastSeen: time.Now(),
-	}
-	store.Add(ws, player)
-
-	// Send initial state to client
-	err = ws.WriteJSON(player)
+	ws, err := upgrader.Upgrade(w, r, nil)
 	if err != nil {
-		log.Printf("Error sending initial state: %v", err)
-		store.Delete(ws)
-		ws.Close()
+		log.Printf("WebSocket upgrade error: %v", err)
 		return
 	}
-	log.Printf("New player connected: %s, total players: %d", playerID, len(store.players))
-
-	// Send all existing players to the new player
-	allPlayers := store.GetAllPlayers()
-	log.Printf("Sending %d existing players to new player %s", len(allPlayers), playerID)
 
-	for _, otherPlayer := range allPlayers {
-		// Don't send the player their own info
-		if otherPlayer.ID == playerID {
-			continue
-		}
-
-		msg := MoveMessage{
-			Type:     "move",
-			PlayerID: otherPlayer.ID,
-			X:        otherPlayer.X,
-			Y:        otherPlayer.Y,
-		}
-
-		// Convert to JSON for logging
-		msgBytes, _ := json.Marshal(msg)
-		log.Printf("Sending existing player to new player: %s", string(msgBytes))
-
-		err := ws.WriteJSON(msg)
-		if err != nil {
-			log.Printf("Error sending existing player data: %v", err)
-		}
-	}
-
-	// Close the connection when this function returns
-	defer func() {
-		ws.Close()
-		store.Delete(ws)
-	}()
-
-	// Handle incoming messages
-	for {
-		// Reset read deadline for each message
-		ws.SetReadDeadline(time.Now().Add(120 * time.Second))
-
-		_, msgBytes, err := ws.ReadMessage()
-		if err != nil {
-			if websocket.IsUnexpectedCloseError(err, websocket.CloseGoingAway, websocket.CloseAbnormalClosure) {
-				log.Printf("Error reading message: %v", err)
+	// A reconnect token presented as a query parameter is resolved before
+	// the client exists at all. Clients that can't set one can instead
+	// send it in their first "hello" message once the connection is
+	// open; handleHello resolves that case through the normal dispatch
+	// loop so no other message type is ever silently discarded waiting
+	// for it.
+	tick := room.currentTick()
+	player, issuedToken := resolvePlayer(room, r.URL.Query().Get("token"), tick)
+	room.store.Add(ws, player)
+
+	client := newClient(ws, room, player, issuedToken, tick)
+	room.addClient(client)
+	go client.writePump()
+
+	// The hello reply carries the player's identity and signed token so
+	// the client can persist it across reloads; the state snapshot that
+	// follows carries the world. Both go through the same writeCh as
+	// everything else so writePump stays the only goroutine that ever
+	// writes to the connection.
+	client.send(Envelope{Type: "hello", Payload: mustMarshal(helloReplyPayload{PlayerID: player.ID, Token: issuedToken})})
+
+	allPlayers := room.store.GetAllPlayers()
+	log.Printf("Player %s in room %s, total players: %d", player.ID, room.ID, len(allPlayers))
+	client.send(Envelope{Type: "state", Payload: mustMarshal(statePayload{Tick: tick, Players: allPlayers})})
+
+	// readPump blocks until the connection closes, then tears everything
+	// down via its own deferred cleanup.
+	client.readPump()
+}
+
+// resolvePlayer validates a presented reconnect token against this room
+// and, if it's signed correctly, unexpired, and still within the
+// disconnected player's grace period, resumes that player. Otherwise it
+// mints a fresh one. Either way it returns a newly signed token: tokens
+// aren't reused across issuances, so a leaked token stops being useful
+// once it expires or the player reconnects with it.
+//
+// tick is stamped onto the player as LastChangedTick regardless of which
+// path is taken, so the player shows up in every other already-connected
+// client's very next delta snapshot instead of staying invisible to them
+// until they first move (LastChangedTick defaults to 0, which is never
+// greater than another client's lastAckTick).
+func resolvePlayer(room *Room, presentedToken string, tick uint64) (Player, string) {
+	if presentedToken != "" {
+		if claims, ok := verifyToken(presentedToken); ok && claims.RoomID == room.ID {
+			if player, ok := room.store.Resume(claims.PlayerID); ok {
+				player.LastSeen = time.Now()
+				player.LastChangedTick = tick
+				log.Printf("Player %s reconnected to room %s", player.ID, room.ID)
+				return player, issueToken(player.ID, room.ID)
 			}
-			break
-		}
-
-		var msg MoveMessage
-		err = json.Unmarshal(msgBytes, &msg)
-		if err != nil {
-			log.Printf("Error parsing message: %v, raw message: %s", err, string(msgBytes))
-			continue
 		}
-
-		log.Printf("Received message from %s: %s", playerID, string(msgBytes))
-
-		// Ensure the message contains the correct player ID
-		// This prevents player ID spoofing
-		if msg.Type == "move" {
-			// Override the player ID with the one we assigned
-			msg.PlayerID = playerID
-
-			// Update player position
-			store.Update(ws, msg.X, msg.Y)
-
-			// Send to broadcast channel
-			broadcast <- msg
-		} else {
-			log.Printf("Ignoring message with unknown type: %s", msg.Type)
-		}
-	}
-}
-
-func handleBroadcast() {
-	for msg := range broadcast {
-		playerCount := 0
-		sentCount := 0
-
-		store.Range(func(ws *websocket.Conn, player Player) bool {
-			playerCount++
-
-			// Don't send message back to originator
-			if player.ID == msg.PlayerID {
-				return true // continue the range loop
-			}
-
-			// Set write deadline for sending
-			ws.SetWriteDeadline(time.Now().Add(5 * time.Second))
-
-			// Log the broadcast
-			log.Printf("Broadcasting movement of player %s to player %s: (%.2f, %.2f)",
-				msg.PlayerID, player.ID, msg.X, msg.Y)
-
-			err := ws.WriteJSON(msg)
-			if err != nil {
-				log.Printf("Error broadcasting to %s: %v", player.ID, err)
-			} else {
-				sentCount++
-			}
-
-			return true // continue the range loop
-		})
-
-		log.Printf("Broadcast complete: sent to %d out of %d players", sentCount, playerCount-1)
 	}
-}
-
-func startCleanupTask() {
-	ticker := time.NewTicker(10 * time.Second)
-	defer ticker.Stop()
 
-	for {
-		<-ticker.C
-		store.CleanupInactivePlayers()
-	}
+	playerID := generateSecureID()
+	player := Player{ID: playerID, X: 0, Y: 0, LastSeen: time.Now(), LastChangedTick: tick}
+	return player, issueToken(playerID, room.ID)
 }
 
 func statusHandler(w http.ResponseWriter, r *http.Request) {
 	w.Header().Set("Content-Type", "text/html")
 
-	// Simple status page
+	allRooms := rooms.List()
+
 	fmt.Fprintf(w, "<html><body>")
 	fmt.Fprintf(w, "<h1>Game Server Status</h1>")
-	fmt.Fprintf(w, "<p>Connected players: %d</p>", len(store.players))
-	fmt.Fprintf(w, "<table border='1'><tr><th>ID</th><th>Position</th><th>Last Seen</th></tr>")
-
-	store.Range(func(ws *websocket.Conn, player Player) bool {
-		fmt.Fprintf(w, "<tr><td>%s</td><td>(%.2f, %.2f)</td><td>%s</td></tr>",
-			player.ID, player.X, player.Y, time.Since(player.LastSeen))
-		return true
-	})
+	fmt.Fprintf(w, "<p>Active rooms: %d</p>", len(allRooms))
+	fmt.Fprintf(w, "<p>Dropped messages: %d | Slow-consumer disconnects: %d</p>",
+		atomic.LoadUint64(&metrics.droppedMessages), atomic.LoadUint64(&metrics.slowConsumerDisconnects))
+	// Passphrase is the join secret for a room, so it's deliberately left
+	// off this public page; a room's owner already has it.
+	fmt.Fprintf(w, "<table border='1'><tr><th>Room</th><th>Players</th><th>Tx B/s</th><th>Rx B/s</th></tr>")
+
+	for _, room := range allRooms {
+		fmt.Fprintf(w, "<tr><td>%s</td><td>%d</td><td>%d</td><td>%d</td></tr>",
+			room.ID, room.store.Count(), room.tx.lastSecond(), room.rx.lastSecond())
+	}
 
 	fmt.Fprintf(w, "</table></body></html>")
 }
 
 func main() {
+	http.HandleFunc("/rooms", createRoomHandler)
+	http.HandleFunc("/rooms/", roomBandwidthHandler)
 	http.HandleFunc("/game", handleConnections)
 	http.HandleFunc("/status", statusHandler)
+	http.HandleFunc("/metrics", metricsHandler)
 
 	// Start background tasks
-	go handleBroadcast()
 	go startCleanupTask()
 
 	log.Println("Game server starting on :8080")
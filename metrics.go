@@ -0,0 +1,133 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+const bandwidthWindowSeconds = 60
+
+// bandwidthWindow is a ring buffer of one-second byte-count buckets. It
+// gives a rolling view of the last bandwidthWindowSeconds seconds of
+// traffic without keeping an unbounded history, which is what the old
+// log-only approach couldn't answer.
+type bandwidthWindow struct {
+	mu      sync.Mutex
+	buckets [bandwidthWindowSeconds]uint64
+	seconds [bandwidthWindowSeconds]int64
+}
+
+func (w *bandwidthWindow) add(n int) {
+	if n <= 0 {
+		return
+	}
+
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	now := time.Now().Unix()
+	idx := int(((now % bandwidthWindowSeconds) + bandwidthWindowSeconds) % bandwidthWindowSeconds)
+	if w.seconds[idx] != now {
+		w.seconds[idx] = now
+		w.buckets[idx] = 0
+	}
+	w.buckets[idx] += uint64(n)
+}
+
+// snapshot returns the window oldest-first. A bucket whose second has
+// aged out of the window (no traffic recently) reads as zero.
+func (w *bandwidthWindow) snapshot() []uint64 {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	now := time.Now().Unix()
+	out := make([]uint64, bandwidthWindowSeconds)
+	for i := range out {
+		sec := now - int64(bandwidthWindowSeconds-1-i)
+		idx := int(((sec % bandwidthWindowSeconds) + bandwidthWindowSeconds) % bandwidthWindowSeconds)
+		if w.seconds[idx] == sec {
+			out[i] = w.buckets[idx]
+		}
+	}
+	return out
+}
+
+// lastSecond returns the most recent bucket's value, a cheap at-a-glance
+// rate for the status page.
+func (w *bandwidthWindow) lastSecond() uint64 {
+	snap := w.snapshot()
+	return snap[len(snap)-1]
+}
+
+// serverMetrics tracks traffic and reliability counters across every
+// room, backing the /metrics endpoint and the status page.
+type serverMetrics struct {
+	tx, rx                  bandwidthWindow
+	droppedMessages         uint64 // atomic
+	slowConsumerDisconnects uint64 // atomic
+}
+
+var metrics serverMetrics
+
+func (m *serverMetrics) recordTx(n int) { m.tx.add(n) }
+func (m *serverMetrics) recordRx(n int) { m.rx.add(n) }
+func (m *serverMetrics) recordDrop()    { atomic.AddUint64(&m.droppedMessages, 1) }
+func (m *serverMetrics) recordSlowConsumerDisconnect() {
+	atomic.AddUint64(&m.slowConsumerDisconnects, 1)
+}
+
+type metricsResponse struct {
+	ConnectedPlayers        int      `json:"connectedPlayers"`
+	DroppedMessages         uint64   `json:"droppedMessages"`
+	SlowConsumerDisconnects uint64   `json:"slowConsumerDisconnects"`
+	TxBytesPerSecond        []uint64 `json:"txBytesPerSecond"`
+	RxBytesPerSecond        []uint64 `json:"rxBytesPerSecond"`
+}
+
+func metricsHandler(w http.ResponseWriter, r *http.Request) {
+	connected := 0
+	for _, room := range rooms.List() {
+		connected += room.store.Count()
+	}
+
+	resp := metricsResponse{
+		ConnectedPlayers:        connected,
+		DroppedMessages:         atomic.LoadUint64(&metrics.droppedMessages),
+		SlowConsumerDisconnects: atomic.LoadUint64(&metrics.slowConsumerDisconnects),
+		TxBytesPerSecond:        metrics.tx.snapshot(),
+		RxBytesPerSecond:        metrics.rx.snapshot(),
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(resp)
+}
+
+type roomBandwidthResponse struct {
+	Tx []uint64 `json:"tx"`
+	Rx []uint64 `json:"rx"`
+}
+
+// roomBandwidthHandler serves GET /rooms/{id}/bandwidth.
+func roomBandwidthHandler(w http.ResponseWriter, r *http.Request) {
+	if !strings.HasSuffix(r.URL.Path, "/bandwidth") {
+		http.NotFound(w, r)
+		return
+	}
+
+	id := strings.TrimSuffix(strings.TrimPrefix(r.URL.Path, "/rooms/"), "/bandwidth")
+	room, ok := rooms.GetByID(id)
+	if !ok {
+		http.Error(w, "room not found", http.StatusNotFound)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(roomBandwidthResponse{
+		Tx: room.tx.snapshot(),
+		Rx: room.rx.snapshot(),
+	})
+}
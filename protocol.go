@@ -0,0 +1,204 @@
+package main
+
+import (
+	"encoding/json"
+	"log"
+	"time"
+
+	"github.com/gorilla/websocket"
+)
+
+// Envelope is the versioned wire format for every client<->server
+// message. Payload decoding is deferred so the registry can dispatch on
+// Type before committing to a concrete struct.
+type Envelope struct {
+	Type    string          `json:"type"`
+	ID      string          `json:"id,omitempty"`
+	Payload json.RawMessage `json:"payload,omitempty"`
+}
+
+// ProtocolError means the client violated the wire protocol itself (bad
+// envelope, unknown type, malformed payload). The connection is closed
+// with ClosePolicyViolation.
+type ProtocolError struct {
+	Message string
+}
+
+func (e *ProtocolError) Error() string { return e.Message }
+
+// UserError means a well-formed request was rejected for an ordinary,
+// expected reason. The connection closes normally rather than being
+// treated as abuse.
+type UserError struct {
+	Message string
+}
+
+func (e *UserError) Error() string { return e.Message }
+
+// errorToWSCloseMessage maps a handler error to the close frame sent
+// just before the connection is torn down.
+func errorToWSCloseMessage(err error) []byte {
+	switch err.(type) {
+	case *ProtocolError:
+		return websocket.FormatCloseMessage(websocket.ClosePolicyViolation, err.Error())
+	case *UserError:
+		return websocket.FormatCloseMessage(websocket.CloseNormalClosure, err.Error())
+	default:
+		return websocket.FormatCloseMessage(websocket.CloseInternalServerErr, "internal error")
+	}
+}
+
+type errorPayload struct {
+	Message string `json:"message"`
+}
+
+type statePayload struct {
+	Tick    uint64   `json:"tick"`
+	Players []Player `json:"players"`
+}
+
+func mustMarshal(v interface{}) json.RawMessage {
+	b, err := json.Marshal(v)
+	if err != nil {
+		log.Printf("Error marshaling payload: %v", err)
+		return json.RawMessage("{}")
+	}
+	return b
+}
+
+type messageHandler func(*Client, json.RawMessage) error
+
+// messageHandlers is the dispatch registry: adding a new message kind
+// means adding an entry here, not touching the read loop.
+var messageHandlers = map[string]messageHandler{
+	"hello": handleHello,
+	"move":  handleMove,
+	"chat":  handleChat,
+	"ping":  handlePing,
+	"leave": handleLeave,
+}
+
+// helloPayload is what a client may send as its own "hello": a version
+// string for logging, and optionally the reconnect token it was issued
+// last time, for clients that can't pass it as a query parameter.
+type helloPayload struct {
+	ClientVersion string `json:"clientVersion,omitempty"`
+	Token         string `json:"token,omitempty"`
+}
+
+// helloReplyPayload is what the server sends as the very first message
+// on a new connection: the player's identity and the signed token that
+// proves it, so the client can persist it and reconnect later.
+type helloReplyPayload struct {
+	PlayerID string `json:"playerId"`
+	Token    string `json:"token"`
+}
+
+// handleHello is also where reconnection is resolved for clients that
+// can't set a query parameter: if the hello carries a token for a
+// different, still-pending player, the connection's identity is swapped
+// to that player and the client is sent an updated hello reply. A token
+// that doesn't verify, has expired, or names a player who isn't pending
+// is treated as absent rather than an error, since a stale or reused
+// token is an ordinary race, not client misbehavior.
+func handleHello(c *Client, payload json.RawMessage) error {
+	var p helloPayload
+	if len(payload) > 0 {
+		if err := json.Unmarshal(payload, &p); err != nil {
+			return &ProtocolError{Message: "invalid hello payload: " + err.Error()}
+		}
+	}
+
+	current := c.playerID()
+	log.Printf("Player %s said hello (client %s)", current, p.ClientVersion)
+
+	if p.Token == "" {
+		return nil
+	}
+
+	claims, ok := verifyToken(p.Token)
+	if !ok || claims.RoomID != c.room.ID || claims.PlayerID == current {
+		return nil
+	}
+
+	resumed, ok := c.room.store.Resume(claims.PlayerID)
+	if !ok {
+		return nil
+	}
+
+	resumed.LastSeen = time.Now()
+	// Stamped here too, not only by the simulation loop on movement, so
+	// the resumed player shows up in every other client's very next
+	// delta snapshot instead of staying invisible until they first move.
+	resumed.LastChangedTick = c.room.currentTick()
+	c.room.clearIntent(current)
+	c.room.store.Reassign(c.ws, resumed)
+
+	token := issueToken(resumed.ID, c.room.ID)
+	c.setPlayer(resumed, token)
+
+	log.Printf("Player %s resumed previous identity %s in room %s", current, resumed.ID, c.room.ID)
+	c.send(Envelope{Type: "hello", Payload: mustMarshal(helloReplyPayload{PlayerID: resumed.ID, Token: token})})
+	return nil
+}
+
+// movePayload is a movement intent, not a position: the simulation tick
+// loop integrates it and clamps the result, so a client can't just state
+// an arbitrary (x, y) and teleport.
+type movePayload struct {
+	DX float64 `json:"dx"`
+	DY float64 `json:"dy"`
+}
+
+func handleMove(c *Client, payload json.RawMessage) error {
+	var p movePayload
+	if err := json.Unmarshal(payload, &p); err != nil {
+		return &ProtocolError{Message: "invalid move payload: " + err.Error()}
+	}
+
+	c.room.setIntent(c.playerID(), p.DX, p.DY)
+	return nil
+}
+
+const maxChatLength = 500
+
+type chatPayload struct {
+	Text string `json:"text"`
+}
+
+type chatBroadcastPayload struct {
+	PlayerID string `json:"playerId"`
+	Text     string `json:"text"`
+}
+
+func handleChat(c *Client, payload json.RawMessage) error {
+	var p chatPayload
+	if err := json.Unmarshal(payload, &p); err != nil {
+		return &ProtocolError{Message: "invalid chat payload: " + err.Error()}
+	}
+	if p.Text == "" {
+		c.sendError("chat message must not be empty")
+		return nil
+	}
+	if len(p.Text) > maxChatLength {
+		c.sendError("chat message too long")
+		return nil
+	}
+
+	c.room.broadcastEnvelope(Envelope{
+		Type:    "chat",
+		Payload: mustMarshal(chatBroadcastPayload{PlayerID: c.playerID(), Text: p.Text}),
+	}, nil)
+	return nil
+}
+
+// handlePing is the application-level keepalive distinct from the
+// websocket control-frame ping in writePump; it just proves the client
+// is still processing messages.
+func handlePing(c *Client, payload json.RawMessage) error {
+	return nil
+}
+
+func handleLeave(c *Client, payload json.RawMessage) error {
+	return &UserError{Message: "left by client request"}
+}
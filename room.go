@@ -0,0 +1,347 @@
+package main
+
+import (
+	"crypto/rand"
+	"encoding/json"
+	"log"
+	"math/big"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/gorilla/websocket"
+)
+
+// Players get a grace period to reconnect with their token before their
+// slot is given up for good, so a flaky connection doesn't mean losing
+// your place in the room.
+const reconnectGracePeriod = 60 * time.Second
+
+// passphraseAlphabet avoids visually ambiguous characters (0/O, 1/I) so a
+// passphrase can be read off a screen and typed back in reliably.
+const passphraseAlphabet = "ABCDEFGHJKLMNPQRSTUVWXYZ23456789"
+
+type pendingPlayer struct {
+	player   Player
+	deadline time.Time
+}
+
+type PlayerStore struct {
+	players map[*websocket.Conn]Player
+	conns   map[string]*websocket.Conn // Map player IDs to connections
+	pending map[string]pendingPlayer   // player ID -> player waiting to reconnect
+	mu      sync.Mutex
+}
+
+func newPlayerStore() *PlayerStore {
+	return &PlayerStore{
+		players: make(map[*websocket.Conn]Player),
+		conns:   make(map[string]*websocket.Conn),
+		pending: make(map[string]pendingPlayer),
+	}
+}
+
+// Add registers a brand-new connection under player.ID. Callers are
+// expected to hand out a fresh ID per connection (generateSecureID) or
+// one already reclaimed via Resume/Reassign, so an ID collision here
+// would mean two live connections sharing an identity rather than an
+// ordinary reconnect; Resume already retires the old mapping before a
+// resumed player is ever added back under its ID.
+func (ps *PlayerStore) Add(ws *websocket.Conn, player Player) {
+	ps.mu.Lock()
+	defer ps.mu.Unlock()
+
+	ps.players[ws] = player
+	ps.conns[player.ID] = ws
+
+	log.Printf("Added player %s. Total players: %d", player.ID, len(ps.players))
+}
+
+// Resume looks up a player that disconnected within the grace period and,
+// if found, hands back their last known position so the caller can rejoin
+// them under the same identity. playerID must already have been proven by
+// the caller (a verified token claim, not anything client-supplied
+// as-is), and is single-use: once claimed, the pending slot is gone.
+func (ps *PlayerStore) Resume(playerID string) (Player, bool) {
+	ps.mu.Lock()
+	defer ps.mu.Unlock()
+
+	pending, ok := ps.pending[playerID]
+	delete(ps.pending, playerID)
+	if !ok || time.Now().After(pending.deadline) {
+		return Player{}, false
+	}
+	return pending.player, true
+}
+
+// Reassign swaps the player bound to ws, used when a client proves via a
+// signed, verified token that it's resuming a different identity than
+// the one it connected with.
+func (ps *PlayerStore) Reassign(ws *websocket.Conn, newPlayer Player) {
+	ps.mu.Lock()
+	defer ps.mu.Unlock()
+
+	if old, ok := ps.players[ws]; ok {
+		delete(ps.conns, old.ID)
+	}
+	ps.players[ws] = newPlayer
+	ps.conns[newPlayer.ID] = ws
+}
+
+// SetPosition applies an authoritative position computed by the
+// simulation tick loop and records which tick produced it, so clients
+// can be told only about players that changed since they last heard.
+func (ps *PlayerStore) SetPosition(ws *websocket.Conn, x, y float64, tick uint64) {
+	ps.mu.Lock()
+	defer ps.mu.Unlock()
+
+	if player, ok := ps.players[ws]; ok {
+		player.X = x
+		player.Y = y
+		player.LastSeen = time.Now()
+		player.LastChangedTick = tick
+		ps.players[ws] = player
+	}
+}
+
+// Snapshot returns a point-in-time copy of every connected player,
+// keyed by connection, for the simulation loop to read without holding
+// the store lock while it integrates positions.
+func (ps *PlayerStore) Snapshot() map[*websocket.Conn]Player {
+	ps.mu.Lock()
+	defer ps.mu.Unlock()
+
+	out := make(map[*websocket.Conn]Player, len(ps.players))
+	for ws, player := range ps.players {
+		out[ws] = player
+	}
+	return out
+}
+
+// Disconnect removes the live connection and keeps the player's ID and
+// position around for reconnectGracePeriod, so a reconnect presenting a
+// valid token for this player can resume them instead of spawning a new
+// one.
+func (ps *PlayerStore) Disconnect(ws *websocket.Conn) {
+	ps.mu.Lock()
+	defer ps.mu.Unlock()
+
+	player, ok := ps.players[ws]
+	if !ok {
+		log.Printf("Warning: Tried to delete unknown player connection")
+		return
+	}
+
+	delete(ps.conns, player.ID)
+	delete(ps.players, ws)
+
+	ps.pending[player.ID] = pendingPlayer{player: player, deadline: time.Now().Add(reconnectGracePeriod)}
+	log.Printf("Player %s disconnected, held for reconnect for %s", player.ID, reconnectGracePeriod)
+}
+
+func (ps *PlayerStore) GetAllPlayers() []Player {
+	ps.mu.Lock()
+	defer ps.mu.Unlock()
+
+	players := make([]Player, 0, len(ps.players))
+	for _, player := range ps.players {
+		players = append(players, player)
+	}
+	return players
+}
+
+func (ps *PlayerStore) Range(f func(ws *websocket.Conn, player Player) bool) {
+	ps.mu.Lock()
+	defer ps.mu.Unlock()
+
+	for ws, player := range ps.players {
+		if !f(ws, player) {
+			break
+		}
+	}
+}
+
+func (ps *PlayerStore) Count() int {
+	ps.mu.Lock()
+	defer ps.mu.Unlock()
+	return len(ps.players)
+}
+
+func (ps *PlayerStore) CleanupInactivePlayers() {
+	ps.mu.Lock()
+	defer ps.mu.Unlock()
+
+	now := time.Now()
+	timeout := 30 * time.Second
+
+	for ws, player := range ps.players {
+		if now.Sub(player.LastSeen) > timeout {
+			log.Printf("Removing inactive player %s", player.ID)
+			delete(ps.conns, player.ID)
+			delete(ps.players, ws)
+			ws.Close()
+		}
+	}
+
+	for playerID, pending := range ps.pending {
+		if now.After(pending.deadline) {
+			delete(ps.pending, playerID)
+		}
+	}
+}
+
+// Room is a single lobby: its own player roster and its own authoritative
+// simulation tick, so movement in one room never reaches another.
+type Room struct {
+	ID         string
+	Passphrase string
+	CreatedAt  time.Time
+
+	store *PlayerStore
+
+	tick     uint64 // accessed atomically; current simulation tick
+	intentMu sync.Mutex
+	intents  map[string]Intent // player ID -> latest movement intent
+
+	clientsMu sync.Mutex
+	clients   map[*websocket.Conn]*Client
+
+	tx, rx bandwidthWindow // per-room traffic, for /rooms/{id}/bandwidth
+}
+
+func newRoom(id, passphrase string) *Room {
+	room := &Room{
+		ID:         id,
+		Passphrase: passphrase,
+		CreatedAt:  time.Now(),
+		store:      newPlayerStore(),
+		intents:    make(map[string]Intent),
+		clients:    make(map[*websocket.Conn]*Client),
+	}
+	go room.runSimulation()
+	return room
+}
+
+func (r *Room) addClient(c *Client) {
+	r.clientsMu.Lock()
+	defer r.clientsMu.Unlock()
+	r.clients[c.ws] = c
+}
+
+func (r *Room) removeClient(c *Client) {
+	r.clientsMu.Lock()
+	defer r.clientsMu.Unlock()
+	delete(r.clients, c.ws)
+}
+
+func (r *Room) client(ws *websocket.Conn) (*Client, bool) {
+	r.clientsMu.Lock()
+	defer r.clientsMu.Unlock()
+	c, ok := r.clients[ws]
+	return c, ok
+}
+
+// broadcastEnvelope fans out a single envelope to every client in the
+// room except the one passed as except (pass nil to include everyone).
+func (r *Room) broadcastEnvelope(env Envelope, except *Client) {
+	r.store.Range(func(ws *websocket.Conn, player Player) bool {
+		if except != nil && ws == except.ws {
+			return true
+		}
+		if client, ok := r.client(ws); ok {
+			client.send(env)
+		}
+		return true
+	})
+}
+
+type RoomStore struct {
+	mu    sync.Mutex
+	rooms map[string]*Room // keyed by passphrase
+}
+
+var rooms = RoomStore{rooms: make(map[string]*Room)}
+
+func generatePassphrase() string {
+	b := make([]byte, 6)
+	for i := range b {
+		n, err := rand.Int(rand.Reader, big.NewInt(int64(len(passphraseAlphabet))))
+		if err != nil {
+			log.Printf("Error generating passphrase: %v", err)
+			b[i] = passphraseAlphabet[0]
+			continue
+		}
+		b[i] = passphraseAlphabet[n.Int64()]
+	}
+	return string(b)
+}
+
+func (rs *RoomStore) Create() *Room {
+	rs.mu.Lock()
+	defer rs.mu.Unlock()
+
+	id := generateSecureID()
+	passphrase := generatePassphrase()
+	room := newRoom(id, passphrase)
+	rs.rooms[passphrase] = room
+
+	log.Printf("Created room %s with passphrase %s", id, passphrase)
+	return room
+}
+
+func (rs *RoomStore) Get(passphrase string) (*Room, bool) {
+	rs.mu.Lock()
+	defer rs.mu.Unlock()
+	room, ok := rs.rooms[passphrase]
+	return room, ok
+}
+
+func (rs *RoomStore) GetByID(id string) (*Room, bool) {
+	rs.mu.Lock()
+	defer rs.mu.Unlock()
+
+	for _, room := range rs.rooms {
+		if room.ID == id {
+			return room, true
+		}
+	}
+	return nil, false
+}
+
+func (rs *RoomStore) List() []*Room {
+	rs.mu.Lock()
+	defer rs.mu.Unlock()
+
+	out := make([]*Room, 0, len(rs.rooms))
+	for _, room := range rs.rooms {
+		out = append(out, room)
+	}
+	return out
+}
+
+func createRoomHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	room := rooms.Create()
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(struct {
+		ID         string `json:"id"`
+		Passphrase string `json:"passphrase"`
+	}{ID: room.ID, Passphrase: room.Passphrase})
+}
+
+func startCleanupTask() {
+	ticker := time.NewTicker(10 * time.Second)
+	defer ticker.Stop()
+
+	for {
+		<-ticker.C
+		for _, room := range rooms.List() {
+			room.store.CleanupInactivePlayers()
+		}
+	}
+}
@@ -0,0 +1,146 @@
+package main
+
+import (
+	"sync/atomic"
+	"time"
+)
+
+const (
+	tickRate     = 20
+	tickInterval = time.Second / tickRate
+	maxSpeed     = 200.0 // units per second; caps how far a single tick can move a player
+	worldMin     = 0.0
+	worldMax     = 1000.0
+)
+
+// Intent is the latest movement input a player has submitted. The
+// simulation loop integrates it into position on the next tick instead
+// of trusting whatever position the client claims to be at, which is
+// what let clients teleport before.
+type Intent struct {
+	DX, DY float64
+}
+
+func clampUnit(v float64) float64 {
+	if v > 1 {
+		return 1
+	}
+	if v < -1 {
+		return -1
+	}
+	return v
+}
+
+func clampWorld(v float64) float64 {
+	if v < worldMin {
+		return worldMin
+	}
+	if v > worldMax {
+		return worldMax
+	}
+	return v
+}
+
+// setIntent records a player's desired movement direction for the next
+// tick. Magnitude is clamped to a unit vector so a client can't exceed
+// maxSpeed by inflating dx/dy.
+func (r *Room) setIntent(playerID string, dx, dy float64) {
+	r.intentMu.Lock()
+	defer r.intentMu.Unlock()
+	r.intents[playerID] = Intent{DX: clampUnit(dx), DY: clampUnit(dy)}
+}
+
+// clearIntent stops simulating a player who has left the room.
+func (r *Room) clearIntent(playerID string) {
+	r.intentMu.Lock()
+	defer r.intentMu.Unlock()
+	delete(r.intents, playerID)
+}
+
+func (r *Room) currentTick() uint64 {
+	return atomic.LoadUint64(&r.tick)
+}
+
+// runSimulation is the authoritative tick loop: every tick it integrates
+// pending intents into position, then pushes each client a snapshot
+// containing only the players that changed since that client's last
+// acknowledged tick. This replaces broadcasting every individual move,
+// so bandwidth scales with how much actually changed rather than with
+// how fast clients send input.
+func (r *Room) runSimulation() {
+	ticker := time.NewTicker(tickInterval)
+	defer ticker.Stop()
+
+	dt := tickInterval.Seconds()
+
+	for range ticker.C {
+		tick := atomic.AddUint64(&r.tick, 1)
+		if r.integrate(dt, tick) {
+			r.broadcastSnapshot(tick)
+		}
+	}
+}
+
+// integrate applies every pending intent to its player's position for
+// one tick and reports whether anything actually moved.
+func (r *Room) integrate(dt float64, tick uint64) bool {
+	r.intentMu.Lock()
+	intents := make(map[string]Intent, len(r.intents))
+	for id, in := range r.intents {
+		intents[id] = in
+	}
+	r.intentMu.Unlock()
+
+	if len(intents) == 0 {
+		return false
+	}
+
+	moved := false
+	for ws, player := range r.store.Snapshot() {
+		intent, ok := intents[player.ID]
+		if !ok || (intent.DX == 0 && intent.DY == 0) {
+			continue
+		}
+
+		newX := clampWorld(player.X + intent.DX*maxSpeed*dt)
+		newY := clampWorld(player.Y + intent.DY*maxSpeed*dt)
+		if newX == player.X && newY == player.Y {
+			continue
+		}
+
+		r.store.SetPosition(ws, newX, newY, tick)
+		moved = true
+	}
+
+	return moved
+}
+
+// broadcastSnapshot sends each connected client the players whose
+// position changed since that client's lastAckTick. Delivery is
+// optimistic: queuing the snapshot onto a client's writeCh counts as
+// the ack, since the channel is the only path to that client anyway.
+func (r *Room) broadcastSnapshot(tick uint64) {
+	full := r.store.Snapshot()
+
+	r.clientsMu.Lock()
+	clients := make([]*Client, 0, len(r.clients))
+	for _, c := range r.clients {
+		clients = append(clients, c)
+	}
+	r.clientsMu.Unlock()
+
+	for _, c := range clients {
+		var delta []Player
+		for _, player := range full {
+			if player.LastChangedTick > c.lastAckTick {
+				delta = append(delta, player)
+			}
+		}
+		if len(delta) == 0 {
+			continue
+		}
+
+		c.send(Envelope{Type: "state", Payload: mustMarshal(statePayload{Tick: tick, Players: delta})})
+		c.lastAckTick = tick
+	}
+}
@@ -0,0 +1,115 @@
+package main
+
+import (
+	"testing"
+
+	"github.com/gorilla/websocket"
+)
+
+func TestClampUnit(t *testing.T) {
+	cases := []struct {
+		in   float64
+		want float64
+	}{
+		{0, 0},
+		{0.5, 0.5},
+		{-0.5, -0.5},
+		{1, 1},
+		{-1, -1},
+		{1.5, 1},
+		{-1.5, -1},
+	}
+	for _, c := range cases {
+		if got := clampUnit(c.in); got != c.want {
+			t.Errorf("clampUnit(%v) = %v, want %v", c.in, got, c.want)
+		}
+	}
+}
+
+func TestClampWorld(t *testing.T) {
+	cases := []struct {
+		in   float64
+		want float64
+	}{
+		{worldMin - 10, worldMin},
+		{worldMin, worldMin},
+		{500, 500},
+		{worldMax, worldMax},
+		{worldMax + 10, worldMax},
+	}
+	for _, c := range cases {
+		if got := clampWorld(c.in); got != c.want {
+			t.Errorf("clampWorld(%v) = %v, want %v", c.in, got, c.want)
+		}
+	}
+}
+
+// newTestRoom builds a Room without starting its background
+// runSimulation goroutine, so integrate can be driven tick-by-tick.
+func newTestRoom() *Room {
+	return &Room{
+		ID:      "test-room",
+		store:   newPlayerStore(),
+		intents: make(map[string]Intent),
+		clients: make(map[*websocket.Conn]*Client),
+	}
+}
+
+func TestIntegrateClampsToMaxSpeed(t *testing.T) {
+	room := newTestRoom()
+	ws := &websocket.Conn{}
+	player := Player{ID: "p1", X: 500, Y: 500}
+	room.store.Add(ws, player)
+
+	// An intent well beyond unit magnitude is clamped by setIntent, but
+	// even a unit-magnitude intent over a long dt should never move the
+	// player further than maxSpeed*dt in one tick.
+	room.setIntent("p1", 1, 0)
+
+	dt := 10.0 // ten full seconds of travel condensed into one tick
+	moved := room.integrate(dt, 1)
+	if !moved {
+		t.Fatalf("integrate() = false, want true")
+	}
+
+	got := room.store.Snapshot()[ws]
+	wantX := 500 + maxSpeed*dt
+	if wantX > worldMax {
+		wantX = worldMax
+	}
+	if got.X != wantX {
+		t.Errorf("X = %v, want %v", got.X, wantX)
+	}
+	if got.LastChangedTick != 1 {
+		t.Errorf("LastChangedTick = %v, want 1", got.LastChangedTick)
+	}
+}
+
+func TestIntegrateClampsToWorldBounds(t *testing.T) {
+	room := newTestRoom()
+	ws := &websocket.Conn{}
+	player := Player{ID: "p1", X: worldMax - 1, Y: 0}
+	room.store.Add(ws, player)
+
+	room.setIntent("p1", 1, 0)
+
+	moved := room.integrate(1.0, 1)
+	if !moved {
+		t.Fatalf("integrate() = false, want true")
+	}
+
+	got := room.store.Snapshot()[ws]
+	if got.X != worldMax {
+		t.Errorf("X = %v, want %v (clamped to worldMax)", got.X, worldMax)
+	}
+}
+
+func TestIntegrateNoOpWithoutIntent(t *testing.T) {
+	room := newTestRoom()
+	ws := &websocket.Conn{}
+	room.store.Add(ws, Player{ID: "p1", X: 10, Y: 10})
+
+	if moved := room.integrate(1.0, 1); moved {
+		t.Errorf("integrate() = true, want false when no intents are pending")
+	}
+}
@@ -0,0 +1,99 @@
+package main
+
+import (
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"log"
+	"os"
+	"strings"
+	"time"
+)
+
+// tokenSecretEnvVar names the environment variable holding the HMAC key
+// used to sign reconnect tokens. Without it, tokens are signed with a
+// secret generated at startup, which is fine for a single process but
+// won't validate a token across a restart.
+const tokenSecretEnvVar = "UNITY_SOCKET_TOKEN_SECRET"
+
+// tokenTTL bounds how long a signed token is honored after issuance,
+// independent of the in-memory reconnect grace period it's used to
+// unlock.
+const tokenTTL = 10 * time.Minute
+
+var tokenSecret = loadTokenSecret()
+
+func loadTokenSecret() []byte {
+	if secret := os.Getenv(tokenSecretEnvVar); secret != "" {
+		return []byte(secret)
+	}
+
+	log.Printf("Warning: %s not set, using an ephemeral token secret (reconnect tokens won't survive a restart)", tokenSecretEnvVar)
+	secret := make([]byte, 32)
+	if _, err := rand.Read(secret); err != nil {
+		log.Printf("Error generating ephemeral token secret: %v", err)
+	}
+	return secret
+}
+
+// tokenClaims is what a reconnect token proves: which player, in which
+// room, as of when. Binding the room prevents a token minted for one
+// room being replayed to join another.
+type tokenClaims struct {
+	PlayerID string `json:"playerId"`
+	RoomID   string `json:"roomId"`
+	IssuedAt int64  `json:"issuedAt"`
+}
+
+// issueToken signs a fresh token for playerID in roomID. The token is
+// self-describing: {claims}.{signature}, both base64url, so verifying it
+// doesn't require a server-side lookup table.
+func issueToken(playerID, roomID string) string {
+	claims := tokenClaims{PlayerID: playerID, RoomID: roomID, IssuedAt: time.Now().Unix()}
+
+	payload, err := json.Marshal(claims)
+	if err != nil {
+		log.Printf("Error marshaling token claims: %v", err)
+		return ""
+	}
+
+	encodedPayload := base64.RawURLEncoding.EncodeToString(payload)
+	return encodedPayload + "." + signToken(encodedPayload)
+}
+
+func signToken(encodedPayload string) string {
+	mac := hmac.New(sha256.New, tokenSecret)
+	mac.Write([]byte(encodedPayload))
+	return base64.RawURLEncoding.EncodeToString(mac.Sum(nil))
+}
+
+// verifyToken checks a token's signature and expiry and, if it holds up,
+// returns the claims it was issued with.
+func verifyToken(token string) (tokenClaims, bool) {
+	encodedPayload, sig, ok := strings.Cut(token, ".")
+	if !ok {
+		return tokenClaims{}, false
+	}
+
+	if !hmac.Equal([]byte(sig), []byte(signToken(encodedPayload))) {
+		return tokenClaims{}, false
+	}
+
+	payload, err := base64.RawURLEncoding.DecodeString(encodedPayload)
+	if err != nil {
+		return tokenClaims{}, false
+	}
+
+	var claims tokenClaims
+	if err := json.Unmarshal(payload, &claims); err != nil {
+		return tokenClaims{}, false
+	}
+
+	if time.Since(time.Unix(claims.IssuedAt, 0)) > tokenTTL {
+		return tokenClaims{}, false
+	}
+
+	return claims, true
+}
@@ -0,0 +1,85 @@
+package main
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"testing"
+	"time"
+)
+
+func TestIssueAndVerifyTokenRoundTrip(t *testing.T) {
+	token := issueToken("player-1", "room-1")
+
+	claims, ok := verifyToken(token)
+	if !ok {
+		t.Fatalf("verifyToken(%q) = false, want true", token)
+	}
+	if claims.PlayerID != "player-1" || claims.RoomID != "room-1" {
+		t.Errorf("claims = %+v, want PlayerID=player-1 RoomID=room-1", claims)
+	}
+}
+
+func TestVerifyTokenRejectsTamperedSignature(t *testing.T) {
+	token := issueToken("player-1", "room-1")
+
+	tampered := token[:len(token)-1] + "x"
+	if tampered == token {
+		t.Fatalf("tampering didn't change the token")
+	}
+
+	if _, ok := verifyToken(tampered); ok {
+		t.Errorf("verifyToken(tampered) = true, want false")
+	}
+}
+
+func TestVerifyTokenRejectsTamperedPayload(t *testing.T) {
+	claims := tokenClaims{PlayerID: "player-1", RoomID: "room-1", IssuedAt: time.Now().Unix()}
+	payload, err := json.Marshal(claims)
+	if err != nil {
+		t.Fatalf("marshal claims: %v", err)
+	}
+	encodedPayload := base64.RawURLEncoding.EncodeToString(payload)
+	sig := signToken(encodedPayload)
+
+	forged := tokenClaims{PlayerID: "someone-else", RoomID: "room-1", IssuedAt: time.Now().Unix()}
+	forgedPayload, err := json.Marshal(forged)
+	if err != nil {
+		t.Fatalf("marshal forged claims: %v", err)
+	}
+	forgedToken := base64.RawURLEncoding.EncodeToString(forgedPayload) + "." + sig
+
+	if _, ok := verifyToken(forgedToken); ok {
+		t.Errorf("verifyToken(forged payload, original signature) = true, want false")
+	}
+}
+
+func TestVerifyTokenRejectsExpired(t *testing.T) {
+	claims := tokenClaims{
+		PlayerID: "player-1",
+		RoomID:   "room-1",
+		IssuedAt: time.Now().Add(-tokenTTL - time.Minute).Unix(),
+	}
+	payload, err := json.Marshal(claims)
+	if err != nil {
+		t.Fatalf("marshal claims: %v", err)
+	}
+	encodedPayload := base64.RawURLEncoding.EncodeToString(payload)
+	expired := encodedPayload + "." + signToken(encodedPayload)
+
+	if _, ok := verifyToken(expired); ok {
+		t.Errorf("verifyToken(expired) = true, want false")
+	}
+}
+
+func TestVerifyTokenRejectsMalformed(t *testing.T) {
+	cases := []string{
+		"",
+		"no-dot-in-this-token",
+		"not-base64!!.not-base64!!",
+	}
+	for _, token := range cases {
+		if _, ok := verifyToken(token); ok {
+			t.Errorf("verifyToken(%q) = true, want false", token)
+		}
+	}
+}